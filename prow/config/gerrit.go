@@ -0,0 +1,138 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+// LabelConfig names the labels a Gerrit instance uses to drive Prow
+// triggering and reporting decisions. Instances that don't use
+// Gerrit's default Code-Review vote as their CQ surface (for example ones
+// with a dedicated Commit-Queue label) can be pointed at their own
+// vocabulary instead of requiring a patch to Prow.
+type LabelConfig struct {
+	// CodeReview is the label Prow reports presubmit status to. Defaults to
+	// "Code-Review" when unset.
+	CodeReview string `json:"code_review,omitempty"`
+	// CQ is the label that, when voted +2, triggers the full presubmit set
+	// and (if a submit queue is configured) queues the change for merge.
+	CQ string `json:"cq,omitempty"`
+	// DryRun is the label that, when voted, triggers the same presubmit set
+	// as CQ but suppresses any submit/queue side effects.
+	DryRun string `json:"dry_run,omitempty"`
+	// SelfApprove is the label self-approving authors vote to bypass
+	// Code-Review from another human.
+	SelfApprove string `json:"self_approve,omitempty"`
+
+	// CqFinished and CqSuccess name the comment markers used to report the
+	// result of a CQ run back to the change.
+	CqFinished string `json:"cq_finished,omitempty"`
+	CqSuccess  string `json:"cq_success,omitempty"`
+	// DryRunFinished and DryRunSuccess name the equivalent markers for a
+	// dry-run (CQ+1) run.
+	DryRunFinished string `json:"dry_run_finished,omitempty"`
+	DryRunSuccess  string `json:"dry_run_success,omitempty"`
+}
+
+// defaultLabelConfig is used for instances with no configured LabelConfig:
+// it mirrors Gerrit's own defaults so existing projects keep working
+// unmodified.
+var defaultLabelConfig = LabelConfig{
+	CodeReview:     "Code-Review",
+	CQ:             "Code-Review",
+	DryRun:         "Code-Review",
+	SelfApprove:    "Code-Review",
+	CqFinished:     "CQFinished",
+	CqSuccess:      "CQSuccess",
+	DryRunFinished: "DryRunFinished",
+	DryRunSuccess:  "DryRunSuccess",
+}
+
+// ChromiumLabelConfig is the preset for Chromium-family Gerrit instances,
+// which vote a dedicated Commit-Queue label instead of Code-Review.
+var ChromiumLabelConfig = LabelConfig{
+	CodeReview:     "Code-Review",
+	CQ:             "Commit-Queue",
+	DryRun:         "Commit-Queue",
+	SelfApprove:    "Code-Review",
+	CqFinished:     "CQFinished",
+	CqSuccess:      "CQSuccess",
+	DryRunFinished: "DryRunFinished",
+	DryRunSuccess:  "DryRunSuccess",
+}
+
+// AndroidLabelConfig is the preset for AOSP-family Gerrit instances.
+var AndroidLabelConfig = LabelConfig{
+	CodeReview:     "Code-Review",
+	CQ:             "Presubmit-Ready",
+	DryRun:         "Presubmit-Ready",
+	SelfApprove:    "Code-Review",
+	CqFinished:     "CQFinished",
+	CqSuccess:      "CQSuccess",
+	DryRunFinished: "DryRunFinished",
+	DryRunSuccess:  "DryRunSuccess",
+}
+
+// namedLabelConfigs lets operators reference a preset by name instead of
+// repeating every label in their own config.
+var namedLabelConfigs = map[string]LabelConfig{
+	"chromium": ChromiumLabelConfig,
+	"android":  AndroidLabelConfig,
+}
+
+// Gerrit is config for the gerrit controller.
+type Gerrit struct {
+	// RateLimit defines how many changes to query per gerrit API call.
+	RateLimit int `json:"ratelimit,omitempty"`
+	// LabelConfig configures, per gerrit instance host, the label schema
+	// that instance uses. Instances absent from this map fall back to
+	// LabelConfigPreset, then to defaultLabelConfig (plain Code-Review).
+	LabelConfig map[string]LabelConfig `json:"label_config,omitempty"`
+	// LabelConfigPreset names a preset in namedLabelConfigs per instance,
+	// as a shorthand for common projects instead of spelling out
+	// LabelConfig in full.
+	LabelConfigPreset map[string]string `json:"label_config_preset,omitempty"`
+	// ReleaseBranches maps a project to the regexp patterns (matched
+	// against the change's target branch) that classify a branch as a
+	// release branch rather than trunk. See IsReleaseBranch.
+	ReleaseBranches map[string][]string `json:"release_branches,omitempty"`
+	// SubmitQueueInstances opts a gerrit instance host into the submit
+	// queue subsystem. Instances absent from this set (the default for
+	// every existing instance) never have CQ+2'd changes enqueued or
+	// auto-submitted, no matter what labelCfg.CQ is voted; a CQ+2 vote
+	// there only ever triggers the presubmit set, same as before the
+	// submit queue existed.
+	SubmitQueueInstances map[string]bool `json:"submit_queue_instances,omitempty"`
+}
+
+// SubmitQueueEnabledFor reports whether instance has opted into the submit
+// queue subsystem. See SubmitQueueInstances.
+func (g Gerrit) SubmitQueueEnabledFor(instance string) bool {
+	return g.SubmitQueueInstances[instance]
+}
+
+// LabelConfigFor returns the label schema configured for instance, falling
+// back to a named preset and finally to plain Code-Review so any instance
+// not explicitly configured keeps working as before.
+func (g Gerrit) LabelConfigFor(instance string) LabelConfig {
+	if cfg, ok := g.LabelConfig[instance]; ok {
+		return cfg
+	}
+	if preset, ok := g.LabelConfigPreset[instance]; ok {
+		if cfg, ok := namedLabelConfigs[preset]; ok {
+			return cfg
+		}
+	}
+	return defaultLabelConfig
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "testing"
+
+func TestLabelConfigFor(t *testing.T) {
+	g := Gerrit{
+		LabelConfig: map[string]LabelConfig{
+			"explicit-review.example.com": ChromiumLabelConfig,
+		},
+		LabelConfigPreset: map[string]string{
+			"preset-review.example.com": "android",
+		},
+	}
+
+	cases := []struct {
+		name     string
+		instance string
+		want     string // expected CQ label
+	}{
+		{name: "explicit override wins", instance: "explicit-review.example.com", want: "Commit-Queue"},
+		{name: "named preset", instance: "preset-review.example.com", want: "Presubmit-Ready"},
+		{name: "unconfigured instance falls back to Code-Review", instance: "unknown-review.example.com", want: "Code-Review"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := g.LabelConfigFor(tc.instance).CQ; got != tc.want {
+				t.Errorf("LabelConfigFor(%q).CQ = %q, want %q", tc.instance, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "testing"
+
+func TestIsReleaseBranch(t *testing.T) {
+	g := Gerrit{
+		ReleaseBranches: map[string][]string{
+			"chromium/src": {`^release-\d+$`},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		project string
+		branch  string
+		want    bool
+	}{
+		{name: "matches release pattern", project: "chromium/src", branch: "release-90", want: true},
+		{name: "trunk does not match", project: "chromium/src", branch: "main", want: false},
+		{name: "unconfigured project never matches", project: "other/project", branch: "release-90", want: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := g.IsReleaseBranch(tc.project, tc.branch); got != tc.want {
+				t.Errorf("IsReleaseBranch(%q, %q) = %v, want %v", tc.project, tc.branch, got, tc.want)
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andygrunwald/go-gerrit"
@@ -40,15 +41,44 @@ import (
 	"k8s.io/test-infra/prow/pjutil"
 )
 
+// gerritVote is the highest vote level found on a change, surfaced as a
+// ProwJob annotation so reporters can render dry-run vs. full CQ runs
+// differently.
+type gerritVote string
+
+const (
+	gerritVoteAnnotation = "prow.k8s.io/gerrit-vote"
+	// gerritSeriesAnnotation holds the comma-separated change IDs of every
+	// ancestor tested alongside the tip, so reporters know who else to
+	// comment on.
+	gerritSeriesAnnotation = "prow.k8s.io/gerrit-series"
+
+	gerritVoteNone   gerritVote = ""
+	gerritVoteDryRun gerritVote = "dry-run"
+	gerritVoteCQ     gerritVote = "cq"
+)
+
 type kubeClient interface {
 	CreateProwJob(prowapi.ProwJob) (prowapi.ProwJob, error)
+	GetProwJob(name string) (prowapi.ProwJob, error)
 }
 
 type gerritClient interface {
 	QueryChanges(lastUpdate time.Time, rateLimit int) map[string][]client.ChangeInfo
 	GetBranchRevision(instance, project, branch string) (string, error)
 	SetReview(instance, id, revision, message string, labels map[string]string) error
+	// Submit actually merges id, as opposed to SetReview which only leaves
+	// a vote or comment.
+	Submit(instance, id string) error
 	Account(instance string) *gerrit.AccountInfo
+	GetChange(instance, id string) (client.ChangeInfo, error)
+	StreamEvents(stop <-chan struct{}) (<-chan client.ChangeEvent, error)
+	// ResolveSeries walks change's commit parents and cross-references open
+	// changes on the same branch to reconstruct the ordered chain of
+	// unmerged dependencies, returning oldest ancestor first and change
+	// itself last. It errors on dependency cycles or a chain that crosses
+	// branches.
+	ResolveSeries(instance string, change client.ChangeInfo) ([]client.ChangeInfo, error)
 }
 
 type configAgent interface {
@@ -63,7 +93,29 @@ type Controller struct {
 
 	lastSyncFallback string
 
-	lastUpdate time.Time
+	// lastUpdateMu guards lastUpdate, which is read and written by both the
+	// ticker-driven Sync and the event-driven handleEvent/ProcessChange path
+	// once Run is in use.
+	lastUpdateMu sync.RWMutex
+	lastUpdate   time.Time
+
+	queue *submitQueue
+}
+
+// getLastUpdate returns the most recent lastUpdate value, safe to call
+// concurrently with setLastUpdate from the event-driven path.
+func (c *Controller) getLastUpdate() time.Time {
+	c.lastUpdateMu.RLock()
+	defer c.lastUpdateMu.RUnlock()
+	return c.lastUpdate
+}
+
+// setLastUpdate updates lastUpdate, safe to call concurrently with
+// getLastUpdate from the polling path.
+func (c *Controller) setLastUpdate(lastUpdate time.Time) {
+	c.lastUpdateMu.Lock()
+	defer c.lastUpdateMu.Unlock()
+	c.lastUpdate = lastUpdate
 }
 
 // NewController returns a new gerrit controller client
@@ -92,12 +144,18 @@ func NewController(lastSyncFallback, cookiefilePath string, projects map[string]
 	}
 	c.Start(cookiefilePath)
 
+	queue, err := loadSubmitQueue(queueStateFile(lastSyncFallback))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load submit queue state: %v", err)
+	}
+
 	return &Controller{
 		kc:               kc,
 		config:           cfg,
 		gc:               c,
 		lastUpdate:       lastUpdate,
 		lastSyncFallback: lastSyncFallback,
+		queue:            queue,
 	}, nil
 }
 
@@ -152,9 +210,10 @@ func (c *Controller) SaveLastSync(lastSync time.Time) error {
 // Sync looks for newly made gerrit changes
 // and creates prowjobs according to specs
 func (c *Controller) Sync() error {
-	syncTime := c.lastUpdate
+	lastUpdate := c.getLastUpdate()
+	syncTime := lastUpdate
 
-	for instance, changes := range c.gc.QueryChanges(c.lastUpdate, c.config().Gerrit.RateLimit) {
+	for instance, changes := range c.gc.QueryChanges(lastUpdate, c.config().Gerrit.RateLimit) {
 		for _, change := range changes {
 			if err := c.ProcessChange(instance, change); err != nil {
 				logrus.WithError(err).Errorf("Failed process change %v", change.CurrentRevision)
@@ -167,11 +226,18 @@ func (c *Controller) Sync() error {
 		logrus.Infof("Processed %d changes for instance %s", len(changes), instance)
 	}
 
-	c.lastUpdate = syncTime
+	c.setLastUpdate(syncTime)
 	if err := c.SaveLastSync(syncTime); err != nil {
 		logrus.WithError(err).Errorf("last sync %v, cannot save to path %v", syncTime, c.lastSyncFallback)
 	}
 
+	if c.queue != nil {
+		c.queue.reconcile(c.gc, c.kc, c.config)
+		if err := c.queue.save(queueStateFile(c.lastSyncFallback)); err != nil {
+			logrus.WithError(err).Error("failed to save submit queue state")
+		}
+	}
+
 	return nil
 }
 
@@ -202,11 +268,12 @@ func listChangedFiles(changeInfo client.ChangeInfo) config.ChangedFilesProvider
 	}
 }
 
-func createRefs(reviewHost string, change client.ChangeInfo, cloneURI *url.URL, baseSHA string) (prowapi.Refs, error) {
-	rev, ok := change.Revisions[change.CurrentRevision]
-	if !ok {
-		return prowapi.Refs{}, fmt.Errorf("cannot find current revision for change %v", change.ID)
-	}
+// createRefs builds the checkout refs for series, an ordered ancestor chain
+// ending with the change under test (a single-element series for a change
+// with no unmerged dependencies). Each entry contributes one Pull so the
+// checkout step applies every ancestor before the tip.
+func createRefs(reviewHost string, series []client.ChangeInfo, cloneURI *url.URL, baseSHA string) (prowapi.Refs, error) {
+	change := series[len(series)-1]
 	var codeHost string // Something like https://android.googlesource.com
 	parts := strings.SplitN(reviewHost, ".", 2)
 	codeHost = strings.TrimSuffix(parts[0], "-review")
@@ -221,21 +288,43 @@ func createRefs(reviewHost string, change client.ChangeInfo, cloneURI *url.URL,
 		CloneURI: cloneURI.String(), // Something like https://android-review.googlesource.com/platform/build
 		RepoLink: fmt.Sprintf("%s/%s", codeHost, change.Project),
 		BaseLink: fmt.Sprintf("%s/%s/+/%s", codeHost, change.Project, baseSHA),
-		Pulls: []prowapi.Pull{
-			{
-				Number:     change.Number,
-				Author:     rev.Commit.Author.Name,
-				SHA:        change.CurrentRevision,
-				Ref:        rev.Ref,
-				Link:       fmt.Sprintf("%s/c/%s/+/%d", reviewHost, change.Project, change.Number),
-				CommitLink: fmt.Sprintf("%s/%s/+/%s", codeHost, change.Project, change.CurrentRevision),
-				AuthorLink: fmt.Sprintf("%s/q/%s", reviewHost, rev.Commit.Author.Email),
-			},
-		},
+	}
+	for _, c := range series {
+		rev, ok := c.Revisions[c.CurrentRevision]
+		if !ok {
+			return prowapi.Refs{}, fmt.Errorf("cannot find current revision for change %v", c.ID)
+		}
+		refs.Pulls = append(refs.Pulls, prowapi.Pull{
+			Number:     c.Number,
+			Author:     rev.Commit.Author.Name,
+			SHA:        c.CurrentRevision,
+			Ref:        rev.Ref,
+			Link:       fmt.Sprintf("%s/c/%s/+/%d", reviewHost, c.Project, c.Number),
+			CommitLink: fmt.Sprintf("%s/%s/+/%s", codeHost, c.Project, c.CurrentRevision),
+			AuthorLink: fmt.Sprintf("%s/q/%s", reviewHost, rev.Commit.Author.Email),
+		})
 	}
 	return refs, nil
 }
 
+// hasApproval reports whether change is eligible to be merged by the submit
+// queue: either a human left a qualifying Code-Review vote, or the change
+// carries labelCfg.SelfApprove, letting its author bypass a human
+// Code-Review approval. On instances whose CQ and SelfApprove labels are
+// both aliases of Code-Review (the default), a qualifying CQ vote already
+// satisfies this by construction.
+func hasApproval(change client.ChangeInfo, labelCfg config.LabelConfig) bool {
+	if vote, ok := change.Labels[labelCfg.CodeReview]; ok && vote.Value >= 2 {
+		return true
+	}
+	if labelCfg.SelfApprove != "" {
+		if vote, ok := change.Labels[labelCfg.SelfApprove]; ok && vote.Value > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // ProcessChange creates new presubmit prowjobs base off the gerrit changes
 func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) error {
 	logger := logrus.WithField("gerrit change", change.Number)
@@ -252,7 +341,21 @@ func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) er
 
 	triggeredJobs := []string{}
 
-	refs, err := createRefs(instance, change, cloneURI, baseSHA)
+	// A merged change is done: there is no longer an open series of
+	// unmerged dependencies to resolve, it is just itself.
+	series := []client.ChangeInfo{change}
+	if change.Status == client.New {
+		series, err = c.gc.ResolveSeries(instance, change)
+		if err != nil {
+			message := fmt.Sprintf("Unable to resolve dependency series for this change: %v", err)
+			if commentErr := c.gc.SetReview(instance, change.ID, change.CurrentRevision, message, nil); commentErr != nil {
+				logger.WithError(commentErr).Warn("failed to comment on change about series resolution failure")
+			}
+			return fmt.Errorf("failed to resolve change series: %v", err)
+		}
+	}
+
+	refs, err := createRefs(instance, series, cloneURI, baseSHA)
 	if err != nil {
 		return fmt.Errorf("failed to get refs: %v", err)
 	}
@@ -266,6 +369,8 @@ func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) er
 
 	changedFiles := listChangedFiles(change)
 
+	labelCfg := c.config().Gerrit.LabelConfigFor(instance)
+
 	switch change.Status {
 	case client.Merged:
 		postsubmits := c.config().Postsubmits[cloneURI.String()]
@@ -297,37 +402,76 @@ func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) er
 			if message.Author.AccountID != account.AccountID {
 				continue
 			}
-			report := reporter.ParseReport(message.Message)
+			report := reporter.ParseReport(message.Message, labelCfg)
 			if report != nil {
 				logrus.Infof("Found latest report: %s", message.Message)
 				latestReport = report
 				break
 			}
 		}
-		filter, err := messageFilter(c.lastUpdate, change, presubmits, latestReport, logger)
+		lastUpdate := c.getLastUpdate()
+		filter, err := messageFilter(lastUpdate, change, presubmits, latestReport, labelCfg, logger)
 		if err != nil {
 			logger.WithError(err).Warn("failed to create filter on messages for presubmits")
 		} else {
 			filters = append(filters, filter)
 		}
-		if change.Revisions[change.CurrentRevision].Created.Time.After(c.lastUpdate) {
+		if change.Revisions[change.CurrentRevision].Created.Time.After(lastUpdate) {
 			filters = append(filters, pjutil.TestAllFilter())
 		}
 		toTrigger, _, err := pjutil.FilterPresubmits(pjutil.AggregateFilter(filters), listChangedFiles(change), change.Branch, presubmits, logger)
 		if err != nil {
 			return fmt.Errorf("failed to filter presubmits: %v", err)
 		}
+		isRelease := c.config().Gerrit.IsReleaseBranch(change.Project, change.Branch)
+		toTrigger = filterForReleaseBranch(isRelease, presubmits, toTrigger)
 		for _, presubmit := range toTrigger {
 			jobSpecs = append(jobSpecs, jobSpec{
 				spec:   pjutil.PresubmitSpec(presubmit, refs),
 				labels: presubmit.Labels,
 			})
 		}
+
+		// Queue (and eventual submit) side effects are keyed strictly off
+		// the CQ label, never DryRun: a dry-run vote runs the same
+		// presubmits for visibility but must never enter the submit queue.
+		// The submit queue itself is opt-in per instance: an instance absent
+		// from SubmitQueueInstances must never be auto-enqueued or
+		// auto-submitted just because it votes CQ+2 on Code-Review, the
+		// default label for every unconfigured instance.
+		if c.queue != nil && c.config().Gerrit.SubmitQueueEnabledFor(instance) {
+			if vote, ok := change.Labels[labelCfg.CQ]; ok && vote.Value >= 2 {
+				if !hasApproval(change, labelCfg) {
+					logger.Info("change is CQ+2 but has neither a Code-Review approval nor a self-approve vote, dropping from the submit queue")
+					c.queue.dequeue(instance, change)
+				} else if err := c.queue.enqueue(instance, series); err != nil {
+					logger.WithError(err).Warn("failed to enqueue change in submit queue")
+				}
+			} else {
+				c.queue.dequeue(instance, change)
+			}
+		}
+	}
+
+	voteLevel := gerritVoteNone
+	if dryRun, ok := change.Labels[labelCfg.DryRun]; ok && dryRun.Value > 0 {
+		voteLevel = gerritVoteDryRun
+	}
+	if cq, ok := change.Labels[labelCfg.CQ]; ok && cq.Value >= 2 {
+		voteLevel = gerritVoteCQ
 	}
 
 	annotations := map[string]string{
 		client.GerritID:       change.ID,
 		client.GerritInstance: instance,
+		gerritVoteAnnotation:  string(voteLevel),
+	}
+	if len(series) > 1 {
+		var ancestorIDs []string
+		for _, ancestor := range series[:len(series)-1] {
+			ancestorIDs = append(ancestorIDs, ancestor.ID)
+		}
+		annotations[gerritSeriesAnnotation] = strings.Join(ancestorIDs, ",")
 	}
 
 	for _, jSpec := range jobSpecs {
@@ -338,7 +482,7 @@ func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) er
 		labels[client.GerritRevision] = change.CurrentRevision
 
 		if gerritLabel, ok := labels[client.GerritReportLabel]; !ok || gerritLabel == "" {
-			labels[client.GerritReportLabel] = client.CodeReview
+			labels[client.GerritReportLabel] = labelCfg.CodeReview
 		}
 
 		pj := pjutil.NewProwJobWithAnnotation(jSpec.spec, labels, annotations)
@@ -357,8 +501,10 @@ func (c *Controller) ProcessChange(instance string, change client.ChangeInfo) er
 			message += fmt.Sprintf("\n  * Name: %s", job)
 		}
 
-		if err := c.gc.SetReview(instance, change.ID, change.CurrentRevision, message, nil); err != nil {
-			return err
+		for _, seriesChange := range series {
+			if err := c.gc.SetReview(instance, seriesChange.ID, seriesChange.CurrentRevision, message, nil); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/test-infra/prow/gerrit/client"
+)
+
+// reconcileInterval is how often Run falls back to a full QueryChanges pass
+// to catch any stream-events that were missed while disconnected.
+const reconcileInterval = 5 * time.Minute
+
+// eventTypes that drive ProcessChange directly instead of waiting for the
+// next reconciliation pass. A ref-updated event carries no change ID, so
+// handleEvent skips it and only the reconciliation pass picks it up.
+var eventTypes = map[string]bool{
+	"patchset-created": true,
+	"comment-added":    true,
+	"change-merged":    true,
+	"ref-updated":      true,
+}
+
+// Run drives the controller for the lifetime of stopCh: it processes Gerrit
+// stream-events as they arrive for low latency, and falls back to (and
+// always periodically runs, as a reconciliation safety net) the polling
+// Sync path so events dropped during a stream disconnect are not lost.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	go c.runEvents(stopCh)
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if err := c.Sync(); err != nil {
+				logrus.WithError(err).Error("reconciliation sync failed")
+			}
+		}
+	}
+}
+
+// runEvents subscribes to the event stream and processes events as they
+// arrive, resubscribing whenever the stream disconnects and backing off
+// between failed subscription attempts. The
+// periodic reconciliation in Run covers changes while no stream is attached.
+func (c *Controller) runEvents(stopCh <-chan struct{}) {
+	backoff := time.Second
+	for {
+		events, err := c.gc.StreamEvents(stopCh)
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to subscribe to gerrit stream-events, falling back to polling for %s", backoff)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < reconcileInterval {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		for event := range events {
+			if !eventTypes[event.Type] {
+				continue
+			}
+			if err := c.handleEvent(event); err != nil {
+				logrus.WithError(err).Errorf("failed to handle %s event for change %s", event.Type, event.ChangeID)
+			}
+		}
+
+		// events channel closed: the stream disconnected, loop around to resubscribe.
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+	}
+}
+
+// handleEvent fetches the current state of the change named by event and
+// runs it through the same ProcessChange path as the polling loop.
+func (c *Controller) handleEvent(event client.ChangeEvent) error {
+	if event.ChangeID == "" {
+		return nil
+	}
+	change, err := c.gc.GetChange(event.Instance, event.ChangeID)
+	if err != nil {
+		return err
+	}
+	return c.ProcessChange(event.Instance, change)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/gerrit/client"
+	"k8s.io/test-infra/prow/gerrit/reporter"
+	"k8s.io/test-infra/prow/pjutil"
+)
+
+// messageFilter decides whether presubmits should run based on the latest
+// report Prow already posted to change, so an unchanged revision doesn't
+// get retriggered on every sync. latestReport is nil when no such report
+// was found, in which case every presubmit is forced to run. (For a
+// revision created since lastUpdate the caller separately adds
+// pjutil.TestAllFilter().)
+//
+// The one case this always forces a full retrigger despite an up-to-date,
+// fully-passing report is a vote transition from dry-run to full CQ: the
+// dry-run report was generated under labelCfg's DryRun markers, but a
+// CQ+2 vote needs every presubmit to have actually run (and reported)
+// under the full-CQ markers before the change is eligible to submit, so a
+// dry-run-only history isn't good enough even without a new patchset.
+func messageFilter(lastUpdate time.Time, change client.ChangeInfo, presubmits []config.Presubmit, latestReport *reporter.JobReport, labelCfg config.LabelConfig, logger *logrus.Entry) (pjutil.Filter, error) {
+	// forceAll runs every presubmit, including ones that otherwise need an
+	// explicit trigger.
+	forceAll := func(p config.Presubmit) (shouldRun, forceRun, defaultBehavior bool) {
+		return true, true, false
+	}
+
+	if latestReport == nil {
+		return forceAll, nil
+	}
+
+	cq, ok := change.Labels[labelCfg.CQ]
+	isFullCQ := ok && cq.Value >= 2
+	if latestReport.DryRun && isFullCQ {
+		logger.Info("vote transitioned from dry-run to full CQ since the last report, forcing a retrigger")
+		return forceAll, nil
+	}
+
+	// Nothing changed since the last report: leave the trigger decision to
+	// whichever other filter (e.g. TestAllFilter for a new revision) knows
+	// better, rather than forcing or skipping anything ourselves.
+	return func(p config.Presubmit) (shouldRun, forceRun, defaultBehavior bool) {
+		return false, false, true
+	}, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-gerrit"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/gerrit/client"
+	"k8s.io/test-infra/prow/gerrit/reporter"
+)
+
+func TestMessageFilter(t *testing.T) {
+	labelCfg := config.LabelConfig{CodeReview: "Code-Review", CQ: "Code-Review", DryRun: "Code-Review"}
+
+	change := client.ChangeInfo{
+		Number:          1,
+		ID:              "change-1",
+		Project:         "proj",
+		Branch:          "main",
+		CurrentRevision: "rev",
+	}
+
+	cases := []struct {
+		name         string
+		labels       map[string]gerrit.LabelInfo
+		latestReport *reporter.JobReport
+		wantForce    bool
+	}{
+		{
+			name:         "no report yet forces a full run",
+			labels:       map[string]gerrit.LabelInfo{"Code-Review": {Value: 2}},
+			latestReport: nil,
+			wantForce:    true,
+		},
+		{
+			name:         "dry-run report still dry-run does not force",
+			labels:       map[string]gerrit.LabelInfo{"Code-Review": {Value: 1}},
+			latestReport: &reporter.JobReport{DryRun: true, Success: 1, Total: 1},
+			wantForce:    false,
+		},
+		{
+			name:         "dry-run report but vote now full CQ forces a retrigger",
+			labels:       map[string]gerrit.LabelInfo{"Code-Review": {Value: 2}},
+			latestReport: &reporter.JobReport{DryRun: true, Success: 1, Total: 1},
+			wantForce:    true,
+		},
+		{
+			name:         "full CQ report and still full CQ does not force",
+			labels:       map[string]gerrit.LabelInfo{"Code-Review": {Value: 2}},
+			latestReport: &reporter.JobReport{DryRun: false, Success: 1, Total: 1},
+			wantForce:    false,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := change
+			c.Labels = tc.labels
+
+			filter, err := messageFilter(time.Now(), c, nil, tc.latestReport, labelCfg, logrus.WithField("test", tc.name))
+			if err != nil {
+				t.Fatalf("messageFilter() returned error: %v", err)
+			}
+
+			var p config.Presubmit
+			shouldRun, forceRun, defaultBehavior := filter(p)
+			forced := shouldRun && forceRun && !defaultBehavior
+			if forced != tc.wantForce {
+				t.Errorf("filter forced a full run = %v, want %v", forced, tc.wantForce)
+			}
+		})
+	}
+}
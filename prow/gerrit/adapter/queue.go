@@ -0,0 +1,404 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	prowapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/gerrit/client"
+	"k8s.io/test-infra/prow/pjutil"
+)
+
+// queueKey identifies the (instance, project, branch) a submit queue serie belongs to.
+type queueKey struct {
+	Instance string
+	Project  string
+	Branch   string
+}
+
+// MarshalText lets queueKey serialize as a map key in the persisted queue state.
+// Each field is query-escaped, so a "/" inside an instance URL, project or
+// branch name cannot be mistaken for the separator.
+func (k queueKey) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%s/%s/%s", url.QueryEscape(k.Instance), url.QueryEscape(k.Project), url.QueryEscape(k.Branch))), nil
+}
+
+// UnmarshalText is the inverse of MarshalText.
+func (k *queueKey) UnmarshalText(text []byte) error {
+	parts := strings.Split(string(text), "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid submit queue key %q", text)
+	}
+	var fields [3]string
+	for i, part := range parts {
+		field, err := url.QueryUnescape(part)
+		if err != nil {
+			return fmt.Errorf("invalid submit queue key %q: %v", text, err)
+		}
+		fields[i] = field
+	}
+	k.Instance, k.Project, k.Branch = fields[0], fields[1], fields[2]
+	return nil
+}
+
+// serie is a rolling, dependency-ordered group of CQ+2'd changes being
+// landed together on a single (instance, project, branch).
+type serie struct {
+	Key     queueKey
+	Changes []client.ChangeInfo // ordered oldest (base) to newest (tip)
+
+	BatchJobName string // name of the in-flight batch ProwJob, empty if none
+	Attempt      int    // number of batch attempts run against the current Changes
+}
+
+// submitQueue tracks one serie per (instance, project, branch) and merges
+// each serie once its tip's batch ProwJob reports success. On failure it
+// bisects the serie into independently-retested candidate batches so a
+// single change is only blamed once it alone has failed a batch.
+type submitQueue struct {
+	mu sync.Mutex
+
+	Series map[queueKey]*serie
+
+	// Bisecting holds the candidate half-series spawned when a serie's
+	// batch job fails. Each candidate is retested on its own; only a
+	// candidate that has been narrowed down to a single change and still
+	// fails gets blamed and evicted.
+	Bisecting map[queueKey][]*serie
+}
+
+func newSubmitQueue() *submitQueue {
+	return &submitQueue{
+		Series:    map[queueKey]*serie{},
+		Bisecting: map[queueKey][]*serie{},
+	}
+}
+
+// loadSubmitQueue restores queue state from path, returning an empty queue
+// if the file does not yet exist so a fresh controller starts cleanly.
+func loadSubmitQueue(path string) (*submitQueue, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newSubmitQueue(), nil
+		}
+		return nil, err
+	}
+	q := newSubmitQueue()
+	if err := json.Unmarshal(buf, q); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal submit queue state: %v", err)
+	}
+	return q, nil
+}
+
+// save persists the queue state so a controller restart does not drop
+// in-flight series, mirroring Controller.SaveLastSync.
+func (q *submitQueue) save(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	buf, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+// queueStateFile derives the submit queue state path from the
+// lastSyncFallback path so the two persist side by side.
+func queueStateFile(lastSyncFallback string) string {
+	return lastSyncFallback + ".queue"
+}
+
+func keyFor(instance string, change client.ChangeInfo) queueKey {
+	return queueKey{Instance: instance, Project: change.Project, Branch: change.Branch}
+}
+
+// enqueue (re)sets the serie for series' tip's (instance, project, branch)
+// to series itself. series must already be ordered oldest (base) to newest
+// (tip) by dependency, as ResolveSeries returns it, so the batch job this
+// serie eventually starts applies every change in the right order instead
+// of whatever order they happened to be synced or evented in.
+func (q *submitQueue) enqueue(instance string, series []client.ChangeInfo) error {
+	if len(series) == 0 {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := keyFor(instance, series[len(series)-1])
+	s, ok := q.Series[key]
+	if !ok {
+		s = &serie{Key: key}
+		q.Series[key] = s
+	}
+	same := len(s.Changes) == len(series)
+	for i := 0; same && i < len(series); i++ {
+		same = s.Changes[i].Number == series[i].Number && s.Changes[i].CurrentRevision == series[i].CurrentRevision
+	}
+	if !same {
+		// A batch already in flight was built from the old Changes, so its
+		// result does not cover series: start over with a fresh batch.
+		s.BatchJobName = ""
+		s.Attempt = 0
+	}
+	s.Changes = series
+	return nil
+}
+
+// dequeue removes change from whichever serie it belongs to, for example
+// when CQ is unset or the change merges outside of the queue.
+func (q *submitQueue) dequeue(instance string, change client.ChangeInfo) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := keyFor(instance, change)
+	s, ok := q.Series[key]
+	if !ok {
+		return
+	}
+	for i, c := range s.Changes {
+		if c.Number == change.Number {
+			s.Changes = append(s.Changes[:i], s.Changes[i+1:]...)
+			break
+		}
+	}
+	if len(s.Changes) == 0 {
+		delete(q.Series, key)
+	}
+}
+
+// reconcile advances every serie by one step: starting a batch job for
+// series with none in flight, acting on the result of a completed one, or
+// stepping an in-progress bisection. cfg supplies the per-instance label
+// schema so merge/evict votes land on the labels the instance actually
+// uses instead of Gerrit's defaults.
+func (q *submitQueue) reconcile(gc gerritClient, kc kubeClient, cfg config.Getter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys := map[queueKey]bool{}
+	for key := range q.Series {
+		keys[key] = true
+	}
+	for key := range q.Bisecting {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		labelCfg := cfg().Gerrit.LabelConfigFor(key.Instance)
+
+		if candidates := q.Bisecting[key]; len(candidates) > 0 {
+			remaining := q.stepBisection(gc, kc, labelCfg, key, candidates)
+			if len(remaining) == 0 {
+				delete(q.Bisecting, key)
+			} else {
+				q.Bisecting[key] = remaining
+			}
+			continue
+		}
+
+		s, ok := q.Series[key]
+		if !ok || len(s.Changes) == 0 {
+			delete(q.Series, key)
+			continue
+		}
+		if s.BatchJobName == "" {
+			if err := s.startBatch(kc); err != nil {
+				logrus.WithError(err).Errorf("failed to start batch job for serie %+v", key)
+			}
+			continue
+		}
+
+		pj, err := kc.GetProwJob(s.BatchJobName)
+		if err != nil {
+			logrus.WithError(err).Errorf("failed to get batch job %s", s.BatchJobName)
+			continue
+		}
+		switch pj.Status.State {
+		case prowapi.SuccessState:
+			if err := mergeSerie(gc, key.Instance, labelCfg, s.Changes); err != nil {
+				logrus.WithError(err).Errorf("failed to merge serie %+v", key)
+			}
+			delete(q.Series, key)
+		case prowapi.FailureState, prowapi.ErrorState:
+			delete(q.Series, key)
+			if len(s.Changes) == 1 {
+				// A single-change serie that fails its batch is already its
+				// own offender: splitSerie would hand back one empty and
+				// one full candidate, and starting a batch for the empty
+				// one panics indexing its (empty) Changes slice.
+				offender := s.Changes[0]
+				if err := blameAndEvict(gc, key.Instance, labelCfg, offender); err != nil {
+					logrus.WithError(err).Errorf("failed to evict offending change %d", offender.Number)
+				}
+			} else {
+				q.Bisecting[key] = splitSerie(key, s.Changes)
+			}
+		}
+	}
+}
+
+// stepBisection advances every in-progress candidate half-serie by one
+// step. A candidate that passes is set aside as cleared; a candidate that
+// fails is split again if it still has more than one change, or blamed and
+// evicted once it is down to a single change. Once every candidate has
+// resolved, any cleared changes are re-formed into a fresh combined serie
+// so the (now hopefully green) remainder gets one more full batch test.
+func (q *submitQueue) stepBisection(gc gerritClient, kc kubeClient, labelCfg config.LabelConfig, key queueKey, candidates []*serie) []*serie {
+	var remaining []*serie
+	var cleared []client.ChangeInfo
+
+	for _, candidate := range candidates {
+		if candidate.BatchJobName == "" {
+			if err := candidate.startBatch(kc); err != nil {
+				logrus.WithError(err).Errorf("failed to start bisect batch for serie %+v", key)
+			}
+			remaining = append(remaining, candidate)
+			continue
+		}
+
+		pj, err := kc.GetProwJob(candidate.BatchJobName)
+		if err != nil {
+			logrus.WithError(err).Errorf("failed to get bisect batch job %s", candidate.BatchJobName)
+			remaining = append(remaining, candidate)
+			continue
+		}
+
+		switch pj.Status.State {
+		case prowapi.SuccessState:
+			cleared = append(cleared, candidate.Changes...)
+		case prowapi.FailureState, prowapi.ErrorState:
+			if len(candidate.Changes) == 1 {
+				offender := candidate.Changes[0]
+				if err := blameAndEvict(gc, key.Instance, labelCfg, offender); err != nil {
+					logrus.WithError(err).Errorf("failed to evict offending change %d", offender.Number)
+				}
+				continue
+			}
+			remaining = append(remaining, splitSerie(key, candidate.Changes)...)
+		default:
+			remaining = append(remaining, candidate)
+		}
+	}
+
+	if len(remaining) == 0 && len(cleared) > 0 {
+		q.requeue(key, cleared)
+	}
+	return remaining
+}
+
+// requeue re-forms the queue around changes that survived bisection,
+// resetting them to a fresh pending serie for a combined re-test.
+func (q *submitQueue) requeue(key queueKey, changes []client.ChangeInfo) {
+	s, ok := q.Series[key]
+	if !ok {
+		s = &serie{Key: key}
+		q.Series[key] = s
+	}
+	s.Changes = append(append([]client.ChangeInfo{}, changes...), s.Changes...)
+	s.BatchJobName = ""
+	s.Attempt = 0
+}
+
+// splitSerie halves changes into two candidate batches to be retested
+// independently, the core step of bisecting on batch failure.
+func splitSerie(key queueKey, changes []client.ChangeInfo) []*serie {
+	mid := len(changes) / 2
+	return []*serie{
+		{Key: key, Changes: append([]client.ChangeInfo{}, changes[:mid]...)},
+		{Key: key, Changes: append([]client.ChangeInfo{}, changes[mid:]...)},
+	}
+}
+
+// blameAndEvict comments on the offending change and removes its CQ vote
+// (named by labelCfg, not a hard-coded label) so it drops out of the queue.
+func blameAndEvict(gc gerritClient, instance string, labelCfg config.LabelConfig, change client.ChangeInfo) error {
+	return gc.SetReview(instance, change.ID, change.CurrentRevision,
+		"Submit queue: this change broke the batch job and has been removed from the queue.",
+		map[string]string{labelCfg.CQ: "0"})
+}
+
+// startBatch synthesizes a batch ProwJob from the tip of the serie: a
+// presubmit-style job whose refs include every change in the serie applied
+// in order, so a green run certifies the whole stack at once.
+func (s *serie) startBatch(kc kubeClient) error {
+	tip := s.Changes[len(s.Changes)-1]
+	spec := prowapi.ProwJobSpec{
+		Type: prowapi.BatchJob,
+		Job:  fmt.Sprintf("submit-queue-%s-%s", s.Key.Project, s.Key.Branch),
+		Refs: seriesRefs(s.Key.Instance, tip, s.Changes),
+	}
+	pj := pjutil.NewProwJob(spec, map[string]string{
+		client.GerritInstance: s.Key.Instance,
+	}, nil)
+	created, err := kc.CreateProwJob(pj)
+	if err != nil {
+		return err
+	}
+	s.BatchJobName = created.Name
+	s.Attempt++
+	return nil
+}
+
+// mergeSerie lands every change in the serie, base first, so Gerrit applies
+// them in dependency order, actually submitting each one rather than just
+// re-voting a label that was already set.
+func mergeSerie(gc gerritClient, instance string, labelCfg config.LabelConfig, changes []client.ChangeInfo) error {
+	for _, change := range changes {
+		if err := gc.SetReview(instance, change.ID, change.CurrentRevision, "Submit queue: batch succeeded, submitting.",
+			map[string]string{labelCfg.CodeReview: "+2"}); err != nil {
+			return fmt.Errorf("failed to vote on change %d before submitting: %v", change.Number, err)
+		}
+		if err := gc.Submit(instance, change.ID); err != nil {
+			return fmt.Errorf("failed to submit change %d: %v", change.Number, err)
+		}
+	}
+	return nil
+}
+
+// seriesRefs builds the checkout refs for a batch job: the branch base plus
+// one Pull per change in the serie, oldest first, so the batch job applies
+// them in dependency order before testing the tip.
+func seriesRefs(instance string, tip client.ChangeInfo, changes []client.ChangeInfo) prowapi.Refs {
+	refs := prowapi.Refs{
+		Org:     instance,
+		Repo:    tip.Project,
+		BaseRef: tip.Branch,
+	}
+	for _, change := range changes {
+		rev := change.Revisions[change.CurrentRevision]
+		refs.Pulls = append(refs.Pulls, prowapi.Pull{
+			Number: change.Number,
+			Author: rev.Commit.Author.Name,
+			SHA:    change.CurrentRevision,
+			Ref:    rev.Ref,
+		})
+	}
+	return refs
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-gerrit"
+
+	prowapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/config"
+	"k8s.io/test-infra/prow/gerrit/client"
+)
+
+// fakeGerritClient records SetReview/Submit calls so tests can assert which
+// change was voted on or merged, keyed by the labels a custom LabelConfig
+// chooses instead of the Gerrit defaults.
+type fakeGerritClient struct {
+	reviews map[string]map[string]string // change ID -> labels set
+	submits map[string]bool              // change ID -> submitted
+}
+
+func newFakeGerritClient() *fakeGerritClient {
+	return &fakeGerritClient{reviews: map[string]map[string]string{}, submits: map[string]bool{}}
+}
+
+func (f *fakeGerritClient) QueryChanges(time.Time, int) map[string][]client.ChangeInfo { return nil }
+func (f *fakeGerritClient) GetBranchRevision(instance, project, branch string) (string, error) {
+	return "", nil
+}
+func (f *fakeGerritClient) SetReview(instance, id, revision, message string, labels map[string]string) error {
+	f.reviews[id] = labels
+	return nil
+}
+func (f *fakeGerritClient) Submit(instance, id string) error {
+	f.submits[id] = true
+	return nil
+}
+func (f *fakeGerritClient) Account(instance string) *gerrit.AccountInfo { return nil }
+func (f *fakeGerritClient) GetChange(instance, id string) (client.ChangeInfo, error) {
+	return client.ChangeInfo{}, nil
+}
+func (f *fakeGerritClient) StreamEvents(stop <-chan struct{}) (<-chan client.ChangeEvent, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (f *fakeGerritClient) ResolveSeries(instance string, change client.ChangeInfo) ([]client.ChangeInfo, error) {
+	return []client.ChangeInfo{change}, nil
+}
+
+type fakeKubeClient struct {
+	jobs map[string]prowapi.ProwJob
+}
+
+func newFakeKubeClient() *fakeKubeClient {
+	return &fakeKubeClient{jobs: map[string]prowapi.ProwJob{}}
+}
+
+func (f *fakeKubeClient) CreateProwJob(pj prowapi.ProwJob) (prowapi.ProwJob, error) {
+	pj.Name = fmt.Sprintf("job-%d", len(f.jobs))
+	f.jobs[pj.Name] = pj
+	return pj, nil
+}
+
+func (f *fakeKubeClient) GetProwJob(name string) (prowapi.ProwJob, error) {
+	pj, ok := f.jobs[name]
+	if !ok {
+		return prowapi.ProwJob{}, fmt.Errorf("no such job %s", name)
+	}
+	return pj, nil
+}
+
+func changeWithNumber(n int) client.ChangeInfo {
+	return client.ChangeInfo{
+		Number:          n,
+		ID:              fmt.Sprintf("change-%d", n),
+		Project:         "proj",
+		Branch:          "main",
+		CurrentRevision: "rev",
+		Revisions: map[string]client.RevisionInfo{
+			"rev": {},
+		},
+	}
+}
+
+func TestSplitSerie(t *testing.T) {
+	key := queueKey{Instance: "host", Project: "proj", Branch: "main"}
+	changes := []client.ChangeInfo{changeWithNumber(1), changeWithNumber(2), changeWithNumber(3)}
+
+	halves := splitSerie(key, changes)
+	if len(halves) != 2 {
+		t.Fatalf("splitSerie() returned %d candidates, want 2", len(halves))
+	}
+	if got := len(halves[0].Changes) + len(halves[1].Changes); got != len(changes) {
+		t.Errorf("splitSerie() dropped changes: got %d total, want %d", got, len(changes))
+	}
+	if len(halves[0].Changes) == 0 || len(halves[1].Changes) == 0 {
+		t.Errorf("splitSerie() produced an empty half: %+v", halves)
+	}
+}
+
+func TestBlameAndEvictUsesConfiguredCQLabel(t *testing.T) {
+	gc := newFakeGerritClient()
+	labelCfg := config.LabelConfig{CQ: "Commit-Queue"}
+	offender := changeWithNumber(1)
+
+	if err := blameAndEvict(gc, "host", labelCfg, offender); err != nil {
+		t.Fatalf("blameAndEvict() = %v", err)
+	}
+
+	labels := gc.reviews[offender.ID]
+	if v, ok := labels["Commit-Queue"]; !ok || v != "0" {
+		t.Errorf("blameAndEvict() set labels %v, want Commit-Queue=0", labels)
+	}
+}
+
+func TestMergeSerieSubmitsEveryChange(t *testing.T) {
+	gc := newFakeGerritClient()
+	labelCfg := config.LabelConfig{CodeReview: "Code-Review"}
+	changes := []client.ChangeInfo{changeWithNumber(1), changeWithNumber(2)}
+
+	if err := mergeSerie(gc, "host", labelCfg, changes); err != nil {
+		t.Fatalf("mergeSerie() = %v", err)
+	}
+
+	for _, change := range changes {
+		if !gc.submits[change.ID] {
+			t.Errorf("mergeSerie() did not submit change %d", change.Number)
+		}
+	}
+}
+
+func TestStepBisectionIsolatesSingleOffender(t *testing.T) {
+	gc := newFakeGerritClient()
+	kc := newFakeKubeClient()
+	key := queueKey{Instance: "host", Project: "proj", Branch: "main"}
+	labelCfg := config.LabelConfig{CQ: "Commit-Queue"}
+
+	good := changeWithNumber(1)
+	bad := changeWithNumber(2)
+	candidates := splitSerie(key, []client.ChangeInfo{good, bad})
+
+	q := newSubmitQueue()
+
+	// Start both candidate batches.
+	remaining := q.stepBisection(gc, kc, labelCfg, key, candidates)
+	if len(remaining) != 2 {
+		t.Fatalf("expected 2 in-flight candidates after starting batches, got %d", len(remaining))
+	}
+
+	// good's batch succeeds, bad's fails.
+	for _, cand := range remaining {
+		pj := kc.jobs[cand.BatchJobName]
+		if cand.Changes[0].Number == good.Number {
+			pj.Status.State = prowapi.SuccessState
+		} else {
+			pj.Status.State = prowapi.FailureState
+		}
+		kc.jobs[cand.BatchJobName] = pj
+	}
+
+	remaining = q.stepBisection(gc, kc, labelCfg, key, remaining)
+	if len(remaining) != 0 {
+		t.Fatalf("expected bisection to resolve single-change candidates, got %d remaining", len(remaining))
+	}
+
+	if labels := gc.reviews[bad.ID]; labels["Commit-Queue"] != "0" {
+		t.Errorf("expected offending change %d to be evicted, reviews: %v", bad.Number, gc.reviews)
+	}
+	if _, blamed := gc.reviews[good.ID]; blamed {
+		t.Errorf("good change %d should not have been blamed", good.Number)
+	}
+
+	// The cleared good change should be re-queued for a fresh combined batch.
+	s, ok := q.Series[key]
+	if !ok || len(s.Changes) != 1 || s.Changes[0].Number != good.Number {
+		t.Errorf("expected good change %d to be requeued, got %+v", good.Number, q.Series[key])
+	}
+}
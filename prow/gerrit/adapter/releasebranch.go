@@ -0,0 +1,62 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"k8s.io/test-infra/prow/config"
+)
+
+const (
+	// skipOnReleaseBranchLabel marks a presubmit as trybot-only: it runs on
+	// trunk but is dropped on branches config.Gerrit classifies as release.
+	skipOnReleaseBranchLabel = "prow.k8s.io/skip-on-release-branch"
+	// runOnReleaseBranchLabel marks a presubmit as mandatory on release
+	// branches, overriding its own file filters.
+	runOnReleaseBranchLabel = "run-on-release-branch"
+)
+
+// filterForReleaseBranch applies release-branch policy on top of the
+// already-filtered toTrigger set: on trunk it is a no-op, on a release
+// branch it drops trybot-only jobs and adds back any job explicitly marked
+// to always run on release branches, regardless of its file filters.
+func filterForReleaseBranch(isRelease bool, presubmits, toTrigger []config.Presubmit) []config.Presubmit {
+	if !isRelease {
+		return toTrigger
+	}
+
+	triggered := make(map[string]bool, len(toTrigger))
+	var filtered []config.Presubmit
+	for _, p := range toTrigger {
+		if p.Labels[skipOnReleaseBranchLabel] == "true" {
+			continue
+		}
+		filtered = append(filtered, p)
+		triggered[p.Name] = true
+	}
+
+	for _, p := range presubmits {
+		if triggered[p.Name] {
+			continue
+		}
+		if _, ok := p.Labels[runOnReleaseBranchLabel]; ok {
+			filtered = append(filtered, p)
+			triggered[p.Name] = true
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/test-infra/prow/config"
+)
+
+func presubmitNamed(name string, labels map[string]string) config.Presubmit {
+	var p config.Presubmit
+	p.Name = name
+	p.Labels = labels
+	return p
+}
+
+func names(presubmits []config.Presubmit) []string {
+	var out []string
+	for _, p := range presubmits {
+		out = append(out, p.Name)
+	}
+	return out
+}
+
+func TestFilterForReleaseBranch(t *testing.T) {
+	trybotOnly := presubmitNamed("trybot-only", map[string]string{skipOnReleaseBranchLabel: "true"})
+	mandatory := presubmitNamed("release-mandatory", map[string]string{runOnReleaseBranchLabel: "true"})
+	plain := presubmitNamed("plain", nil)
+
+	allPresubmits := []config.Presubmit{trybotOnly, mandatory, plain}
+
+	cases := []struct {
+		name      string
+		isRelease bool
+		toTrigger []config.Presubmit
+		want      []string
+	}{
+		{
+			name:      "trunk is a no-op",
+			isRelease: false,
+			toTrigger: []config.Presubmit{trybotOnly, plain},
+			want:      []string{"trybot-only", "plain"},
+		},
+		{
+			name:      "release branch drops trybot-only jobs",
+			isRelease: true,
+			toTrigger: []config.Presubmit{trybotOnly, plain},
+			want:      []string{"plain"},
+		},
+		{
+			name:      "release branch adds back mandatory jobs the file filter skipped",
+			isRelease: true,
+			toTrigger: []config.Presubmit{plain},
+			want:      []string{"plain", "release-mandatory"},
+		},
+		{
+			name:      "mandatory job already triggered is not duplicated",
+			isRelease: true,
+			toTrigger: []config.Presubmit{mandatory},
+			want:      []string{"release-mandatory"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := names(filterForReleaseBranch(tc.isRelease, allPresubmits, tc.toTrigger))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("filterForReleaseBranch() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
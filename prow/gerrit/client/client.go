@@ -0,0 +1,64 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client wraps the Gerrit REST and SSH APIs the adapter controller
+// needs: querying/voting on changes over REST, and watching stream-events
+// over SSH for low-latency triggering.
+package client
+
+import (
+	"sync"
+
+	"github.com/andygrunwald/go-gerrit"
+	"golang.org/x/crypto/ssh"
+)
+
+// Client is a (possibly multi-instance) Gerrit client. REST calls
+// (QueryChanges, GetBranchRevision, SetReview, Submit, Account, GetChange)
+// go through restClients; StreamEvents goes through a separate sshClients
+// connection since REST has no event stream.
+type Client struct {
+	mu          sync.Mutex
+	restClients map[string]*gerrit.Client
+	sshClients  map[string]*ssh.Client
+}
+
+// clientFor returns the REST client for instance, if one has been
+// authenticated for it.
+func (c *Client) clientFor(instance string) (*gerrit.Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	gc, ok := c.restClients[instance]
+	return gc, ok
+}
+
+// sshClientFor returns the SSH connection used for instance's
+// stream-events, if one has already been dialed.
+func (c *Client) sshClientFor(instance string) (*ssh.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, ok := c.sshClients[instance]
+	if !ok {
+		return nil, errNoSSHClient(instance)
+	}
+	return conn, nil
+}
+
+type errNoSSHClient string
+
+func (e errNoSSHClient) Error() string {
+	return "no ssh client configured for instance " + string(e)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andygrunwald/go-gerrit"
+	"golang.org/x/crypto/ssh"
+)
+
+// ChangeEvent is one message off a Gerrit stream-events connection, carrying
+// just enough to look the affected change back up via GetChange.
+type ChangeEvent struct {
+	// Type is the stream-events event name, e.g. "patchset-created",
+	// "comment-added", "change-merged" or "ref-updated".
+	Type string `json:"type"`
+	// Instance is the gerrit host the event came from.
+	Instance string `json:"-"`
+	// ChangeID is the Gerrit change ID the event refers to. Empty for
+	// events (like ref-updated) that aren't about a single change.
+	ChangeID string `json:"-"`
+}
+
+// streamEvent is the wire shape of a stream-events message; only the
+// fields needed to build a ChangeEvent are modeled.
+type streamEvent struct {
+	Type   string `json:"type"`
+	Change struct {
+		ID string `json:"id"`
+	} `json:"change"`
+}
+
+// StreamEvents opens an SSH stream-events session to every configured
+// instance and fans their ChangeEvents into one channel, closing it once
+// stop fires or every connection has dropped so callers fall back to
+// polling.
+func (c *Client) StreamEvents(stop <-chan struct{}) (<-chan ChangeEvent, error) {
+	c.mu.Lock()
+	instances := make([]string, 0, len(c.sshClients))
+	for instance := range c.sshClients {
+		instances = append(instances, instance)
+	}
+	c.mu.Unlock()
+
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instances configured for stream-events")
+	}
+
+	// Open every instance's session up front, before starting any reader
+	// goroutine. That way a later instance failing to dial can only ever
+	// mean closing sessions nothing has started reading from yet, instead
+	// of leaking goroutines that are already blocked sending into events
+	// (which runEvents's retry loop would otherwise have just discarded).
+	type instanceSession struct {
+		instance string
+		session  *ssh.Session
+		stdout   io.Reader
+	}
+	sessions := make([]instanceSession, 0, len(instances))
+	closeSessions := func() {
+		for _, is := range sessions {
+			is.session.Close()
+		}
+	}
+	for _, instance := range instances {
+		session, err := c.streamEventsSession(instance)
+		if err != nil {
+			closeSessions()
+			return nil, fmt.Errorf("failed to open stream-events session for %s: %v", instance, err)
+		}
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			session.Close()
+			closeSessions()
+			return nil, fmt.Errorf("failed to attach to stream-events stdout for %s: %v", instance, err)
+		}
+		if err := session.Start("gerrit stream-events"); err != nil {
+			session.Close()
+			closeSessions()
+			return nil, fmt.Errorf("failed to start stream-events for %s: %v", instance, err)
+		}
+		sessions = append(sessions, instanceSession{instance: instance, session: session, stdout: stdout})
+	}
+
+	events := make(chan ChangeEvent)
+	var wg sync.WaitGroup
+	for _, is := range sessions {
+		is := is
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer is.session.Close()
+
+			scanner := bufio.NewScanner(is.stdout)
+			for scanner.Scan() {
+				var raw streamEvent
+				if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+					continue
+				}
+				event := ChangeEvent{Type: raw.Type, Instance: is.instance, ChangeID: raw.Change.ID}
+				select {
+				case events <- event:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	// Closing the sessions once stop fires unblocks readers waiting in Scan,
+	// so the channel closes even when no further event arrives.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			closeSessions()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(done)
+		close(events)
+	}()
+
+	return events, nil
+}
+
+// streamEventsSession opens the SSH session stream-events runs over. The
+// concrete transport (cookiefile-based auth, host key handling) lives
+// alongside the rest of Client's SSH plumbing.
+func (c *Client) streamEventsSession(instance string) (*ssh.Session, error) {
+	conn, err := c.sshClientFor(instance)
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewSession()
+}
+
+// GetChange fetches the current state of change id on instance, used by
+// the event-driven path to turn a ChangeEvent into a full ChangeInfo before
+// handing it to ProcessChange.
+func (c *Client) GetChange(instance, id string) (ChangeInfo, error) {
+	gerritClient, ok := c.clientFor(instance)
+	if !ok {
+		return ChangeInfo{}, fmt.Errorf("no gerrit client configured for instance %s", instance)
+	}
+
+	change, _, err := gerritClient.Changes.GetChange(id, &gerrit.ChangeOptions{
+		AdditionalFields: []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "MESSAGES", "LABELS"},
+	})
+	if err != nil {
+		return ChangeInfo{}, fmt.Errorf("failed to get change %s: %v", id, err)
+	}
+	return ChangeInfo(*change), nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/andygrunwald/go-gerrit"
+)
+
+// ResolveSeries walks change's commit parents and cross-references open
+// changes on the same project and branch to reconstruct the ordered chain
+// of unmerged dependencies: oldest ancestor first, change itself last. A
+// parent commit that isn't an open change (merged, or not a Gerrit change
+// at all) ends the walk there. It errors on a dependency cycle or on a
+// parent that turns out to live on a different branch.
+func (c *Client) ResolveSeries(instance string, change ChangeInfo) ([]ChangeInfo, error) {
+	gc, ok := c.clientFor(instance)
+	if !ok {
+		return nil, fmt.Errorf("no gerrit client configured for instance %s", instance)
+	}
+
+	series := []ChangeInfo{change}
+	seen := map[string]bool{change.ID: true}
+
+	current := change
+	for {
+		rev, ok := current.Revisions[current.CurrentRevision]
+		if !ok {
+			return nil, fmt.Errorf("change %d missing current revision %s", current.Number, current.CurrentRevision)
+		}
+		if len(rev.Commit.Parents) == 0 {
+			break
+		}
+
+		parent, found, err := findOpenChangeByCommit(gc, current.Project, current.Branch, rev.Commit.Parents[0].Sha)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up parent of change %d: %v", current.Number, err)
+		}
+		if !found {
+			break
+		}
+		if parent.Project != change.Project || parent.Branch != change.Branch {
+			return nil, fmt.Errorf("change %d depends on change %d on a different project/branch (%s/%s), series cannot cross branches", current.Number, parent.Number, parent.Project, parent.Branch)
+		}
+		if seen[parent.ID] {
+			return nil, fmt.Errorf("dependency cycle detected resolving series for change %d: change %d already seen", change.Number, parent.Number)
+		}
+
+		seen[parent.ID] = true
+		series = append(series, parent)
+		current = parent
+	}
+
+	for i, j := 0, len(series)-1; i < j; i, j = i+1, j-1 {
+		series[i], series[j] = series[j], series[i]
+	}
+	return series, nil
+}
+
+// findOpenChangeByCommit looks up the open change (if any) whose current
+// revision is sha, scoped to project so an unrelated change sharing a commit
+// hash in another project can't be mistaken for a dependency. The branch is
+// not part of the query, so a parent on another branch is still found (and
+// rejected by ResolveSeries); when sha is open on several branches, the one
+// on branch is preferred. The current revision and commit are fetched so the
+// walk can continue from the returned change.
+func findOpenChangeByCommit(gc *gerrit.Client, project, branch, sha string) (ChangeInfo, bool, error) {
+	query := fmt.Sprintf("project:%s commit:%s status:open", project, sha)
+	changes, _, err := gc.Changes.QueryChanges(&gerrit.QueryChangeOptions{
+		QueryOptions:  gerrit.QueryOptions{Query: []string{query}},
+		ChangeOptions: gerrit.ChangeOptions{AdditionalFields: []string{"CURRENT_REVISION", "CURRENT_COMMIT"}},
+	})
+	if err != nil {
+		return ChangeInfo{}, false, err
+	}
+	if changes == nil || len(*changes) == 0 {
+		return ChangeInfo{}, false, nil
+	}
+	for _, change := range *changes {
+		if change.Branch == branch {
+			return ChangeInfo(change), true, nil
+		}
+	}
+	return ChangeInfo((*changes)[0]), true, nil
+}
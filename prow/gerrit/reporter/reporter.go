@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reporter implements a Gerrit comment reporter for prowjobs.
+package reporter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"k8s.io/test-infra/prow/config"
+)
+
+// JobReport is the result of parsing a previous Prow status comment left on
+// a Gerrit change, used to avoid re-triggering jobs that already reported.
+type JobReport struct {
+	// Success is the number of jobs that passed.
+	Success int
+	// Total is the number of jobs that were run.
+	Total int
+	// DryRun is true if the report describes a dry-run (CQ+1) comment
+	// rather than a full CQ (CQ+2) comment.
+	DryRun bool
+}
+
+// reportRe matches the "N out of M jobs" summary line Prow leaves on a
+// change after a report comment, e.g. "3 out of 4 jobs succeeded".
+var reportRe = regexp.MustCompile(`(\d+) out of (\d+) jobs? succeeded`)
+
+// ParseReport extracts a JobReport from a Gerrit comment message, or nil if
+// the message is not a Prow report. labelCfg distinguishes a CqFinished
+// from a DryRunFinished comment so callers can tell which vote the report
+// corresponds to.
+func ParseReport(message string, labelCfg config.LabelConfig) *JobReport {
+	matches := reportRe.FindStringSubmatch(message)
+	if matches == nil {
+		return nil
+	}
+	success, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return nil
+	}
+	total, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return nil
+	}
+
+	report := &JobReport{Success: success, Total: total}
+	if labelCfg.DryRunFinished != "" && containsMarker(message, labelCfg.DryRunFinished) {
+		report.DryRun = true
+	}
+	return report
+}
+
+func containsMarker(message, marker string) bool {
+	return marker != "" && regexp.MustCompile(regexp.QuoteMeta(marker)).MatchString(message)
+}
+
+// gerritClient is the subset of gerrit client functionality Client needs to
+// post a report comment.
+type gerritClient interface {
+	SetReview(instance, id, revision, message string, labels map[string]string) error
+}
+
+// Client posts Prow job results back to Gerrit as a review comment, the
+// inverse of ParseReport: a later ParseReport call on the comment it posts
+// here reconstructs the same JobReport.
+type Client struct {
+	gc gerritClient
+}
+
+// NewClient returns a reporter Client that posts through gc.
+func NewClient(gc gerritClient) *Client {
+	return &Client{gc: gc}
+}
+
+// Report posts a summary comment for a finished run of total jobs, success
+// of which passed, back to id's current revision. dryRun selects
+// labelCfg's DryRunFinished/DryRunSuccess markers (the run was triggered by
+// a dry-run vote) over its CqFinished/CqSuccess markers, so the comment
+// ParseReport later reads back carries the right JobReport.DryRun value.
+func (c *Client) Report(instance, id, revision string, success, total int, dryRun bool, labelCfg config.LabelConfig) error {
+	return c.gc.SetReview(instance, id, revision, GenerateReport(success, total, dryRun, labelCfg), nil)
+}
+
+// GenerateReport builds the comment text Report posts, and what ParseReport
+// expects to parse back out.
+func GenerateReport(success, total int, dryRun bool, labelCfg config.LabelConfig) string {
+	finishedMarker, successMarker := labelCfg.CqFinished, labelCfg.CqSuccess
+	if dryRun {
+		finishedMarker, successMarker = labelCfg.DryRunFinished, labelCfg.DryRunSuccess
+	}
+
+	message := finishedMarker
+	if message != "" {
+		message += "\n"
+	}
+	message += fmt.Sprintf("%d out of %d jobs succeeded.", success, total)
+	if success == total && successMarker != "" {
+		message += "\n" + successMarker
+	}
+	return message
+}
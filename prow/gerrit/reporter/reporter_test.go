@@ -0,0 +1,60 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reporter
+
+import (
+	"testing"
+
+	"k8s.io/test-infra/prow/config"
+)
+
+var testLabelCfg = config.LabelConfig{
+	CqFinished:     "CQFinished",
+	CqSuccess:      "CQSuccess",
+	DryRunFinished: "DryRunFinished",
+	DryRunSuccess:  "DryRunSuccess",
+}
+
+func TestGenerateReportParseReportRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		success int
+		total   int
+		dryRun  bool
+	}{
+		{name: "full CQ success", success: 3, total: 3, dryRun: false},
+		{name: "full CQ partial failure", success: 2, total: 3, dryRun: false},
+		{name: "dry run success", success: 4, total: 4, dryRun: true},
+		{name: "dry run partial failure", success: 1, total: 4, dryRun: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := GenerateReport(tc.success, tc.total, tc.dryRun, testLabelCfg)
+
+			report := ParseReport(message, testLabelCfg)
+			if report == nil {
+				t.Fatalf("ParseReport(%q) = nil, want a report", message)
+			}
+			if report.Success != tc.success || report.Total != tc.total {
+				t.Errorf("ParseReport(%q) = %+v, want Success=%d Total=%d", message, report, tc.success, tc.total)
+			}
+			if report.DryRun != tc.dryRun {
+				t.Errorf("ParseReport(%q).DryRun = %v, want %v", message, report.DryRun, tc.dryRun)
+			}
+		})
+	}
+}